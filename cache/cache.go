@@ -0,0 +1,117 @@
+// Package cache tracks the most recently observed parser.Snapshot for each
+// TCP connection, so that a collector can tell whether a poll produced any
+// new information worth recording.
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/michaelasp/test_tcp/parser"
+)
+
+// Cache keeps the last Snapshot seen for each connection, keyed by the
+// connection's InetDiagMsg.ID (5-tuple + cookie).
+type Cache struct {
+	last map[string]*parser.Snapshot
+	// sweep tracks which keys were updated during the current polling
+	// round, so Expire can tell which connections disappeared.
+	sweep map[string]bool
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{
+		last:  make(map[string]*parser.Snapshot),
+		sweep: make(map[string]bool),
+	}
+}
+
+// key identifies a connection by its 5-tuple and cookie, which together are
+// stable for the lifetime of the socket.
+func key(snap *parser.Snapshot) string {
+	id := snap.InetDiagMsg.ID
+	return fmt.Sprintf("%s:%d-%s:%d/%x", id.SrcIP(), id.SPort(), id.DstIP(), id.DPort(), id.Cookie())
+}
+
+// Update records snap as the latest observation for its connection and
+// reports whether the meaningful TCP fields changed since the previous
+// poll. The first observation of a connection is always reported changed.
+func (c *Cache) Update(snap *parser.Snapshot) (prev *parser.Snapshot, changed bool) {
+	k := key(snap)
+	c.sweep[k] = true
+	prev = c.last[k]
+	c.last[k] = snap
+	if prev == nil {
+		return nil, true
+	}
+	return prev, differs(prev, snap)
+}
+
+// differs reports whether the TCP fields that matter for a change-only
+// stream moved between prev and cur. Volatile fields that tick on every
+// poll regardless of real activity - e.g. timers like LastDataSent - are
+// deliberately excluded.
+func differs(prev, cur *parser.Snapshot) bool {
+	if (prev.TCPInfo == nil) != (cur.TCPInfo == nil) {
+		return true
+	}
+	if prev.TCPInfo != nil && cur.TCPInfo != nil {
+		p, c := prev.TCPInfo, cur.TCPInfo
+		// TotalRetrans is the lifetime retransmit counter, so any bump means
+		// loss happened since the last poll; Retransmits (the kernel's
+		// current consecutive-timeout backoff count) resets to 0 as soon as
+		// an ACK arrives and would miss retransmits that happened between
+		// polls but were already acked by the next one.
+		if p.BytesAcked != c.BytesAcked || p.BytesReceived != c.BytesReceived ||
+			p.SegsIn != c.SegsIn || p.SegsOut != c.SegsOut ||
+			p.State != c.State || p.TotalRetrans != c.TotalRetrans {
+			return true
+		}
+	}
+	if (prev.MemInfo == nil) != (cur.MemInfo == nil) {
+		return true
+	}
+	if prev.MemInfo != nil && cur.MemInfo != nil && *prev.MemInfo != *cur.MemInfo {
+		return true
+	}
+	if (prev.BBRInfo == nil) != (cur.BBRInfo == nil) {
+		return true
+	}
+	if prev.BBRInfo != nil && cur.BBRInfo != nil && *prev.BBRInfo != *cur.BBRInfo {
+		return true
+	}
+	return prev.CongestionAlgorithm != cur.CongestionAlgorithm
+}
+
+// tcpClose is the raw tcpi_state value for TCP_CLOSE, from
+// include/net/tcp_states.h. Expire stamps it onto the TCPInfo it
+// synthesizes so a closed-connection record can't be mistaken for a
+// duplicate of the last real, still-open observation.
+const tcpClose = 7
+
+// Expire drops entries for connections that were not touched by Update
+// since the last call to Expire, and returns a synthetic "closed" Snapshot
+// for each one so a caller can emit a final record before forgetting it.
+// The returned Snapshot's TCPInfo.State is forced to tcpClose, regardless of
+// the state last observed, so consumers can tell it apart from a duplicate
+// of the connection's last open snapshot.
+func (c *Cache) Expire(ts time.Time) []*parser.Snapshot {
+	var closed []*parser.Snapshot
+	for k, snap := range c.last {
+		if c.sweep[k] {
+			continue
+		}
+		s := *snap
+		s.Timestamp = ts
+		if s.TCPInfo != nil {
+			info := *s.TCPInfo
+			info.State = tcpClose
+			s.TCPInfo = &info
+		}
+		closed = append(closed, &s)
+		delete(c.last, k)
+	}
+	c.sweep = make(map[string]bool)
+	return closed
+}
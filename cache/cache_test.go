@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/michaelasp/test_tcp/inetdiag"
+	"github.com/michaelasp/test_tcp/parser"
+	"github.com/michaelasp/test_tcp/tcp"
+)
+
+// sizeofInetDiagMsg mirrors procfs.sizeofInetDiagMsg: sizeof(struct
+// inet_diag_msg) on the wire, 4 header bytes + 48-byte inet_diag_sockid + 5
+// trailing __u32s.
+const sizeofInetDiagMsg = 4 + 48 + 5*4
+
+// snap builds a minimal Snapshot keyed by cookie, with the given
+// TotalRetrans, for exercising Cache without a real netlink source.
+func snap(cookie uint32, totalRetrans uint32) *parser.Snapshot {
+	raw := make(inetdiag.RawInetDiagMsg, sizeofInetDiagMsg)
+	binary.LittleEndian.PutUint32(raw[44:48], cookie)
+	idm, err := raw.Parse()
+	if err != nil {
+		panic(err)
+	}
+	return &parser.Snapshot{
+		InetDiagMsg: idm,
+		TCPInfo:     &tcp.LinuxTCPInfo{TotalRetrans: totalRetrans},
+	}
+}
+
+func TestUpdateFirstObservationIsAlwaysChanged(t *testing.T) {
+	c := NewCache()
+	prev, changed := c.Update(snap(1, 0))
+	if prev != nil || !changed {
+		t.Fatalf("got prev=%v changed=%v, want nil, true", prev, changed)
+	}
+}
+
+func TestUpdateIgnoresUnchangedRetransmits(t *testing.T) {
+	c := NewCache()
+	c.Update(snap(1, 3))
+	if _, changed := c.Update(snap(1, 3)); changed {
+		t.Fatal("Update reported a change when TotalRetrans was identical")
+	}
+}
+
+func TestUpdateReportsRetransmitIncrease(t *testing.T) {
+	c := NewCache()
+	c.Update(snap(1, 3))
+	if _, changed := c.Update(snap(1, 4)); !changed {
+		t.Fatal("Update did not report a change when TotalRetrans increased")
+	}
+}
+
+func TestExpireDropsConnectionsMissingFromTheLatestSweep(t *testing.T) {
+	c := NewCache()
+	c.Update(snap(1, 0))
+
+	// The round that touched the connection must not report it closed.
+	closed := c.Expire(time.Now())
+	if len(closed) != 0 {
+		t.Fatalf("got %d closed snapshots, want 0", len(closed))
+	}
+
+	// A connection untouched since the previous Expire is gone for good.
+	closed = c.Expire(time.Now())
+	if len(closed) != 1 {
+		t.Fatalf("got %d closed snapshots on second Expire, want 1", len(closed))
+	}
+}
+
+func TestExpireMarksClosedConnectionsWithTCPClose(t *testing.T) {
+	c := NewCache()
+	s := snap(1, 0)
+	s.TCPInfo.State = 1 // ESTABLISHED
+	c.Update(s)
+
+	// The round that touched the connection must not report it closed.
+	closed := c.Expire(time.Now())
+	if len(closed) != 0 {
+		t.Fatalf("got %d closed snapshots, want 0", len(closed))
+	}
+
+	closed = c.Expire(time.Now())
+	if len(closed) != 1 {
+		t.Fatalf("got %d closed snapshots on second Expire, want 1", len(closed))
+	}
+	if got := closed[0].TCPInfo.State; got != tcpClose {
+		t.Errorf("closed TCPInfo.State = %d, want %d (TCP_CLOSE)", got, tcpClose)
+	}
+	if s.TCPInfo.State != 1 {
+		t.Errorf("Expire mutated the original Snapshot's TCPInfo, State = %d, want 1", s.TCPInfo.State)
+	}
+}
@@ -0,0 +1,30 @@
+package collector
+
+import "time"
+
+// Config controls a long-running Run loop.
+type Config struct {
+	// Interval between polls of the Source. Defaults to 100ms.
+	Interval time.Duration
+
+	// OutDir is the directory time-rotated snapshot files are written to.
+	OutDir string
+
+	// RotateEvery is how often the output file is rotated. Defaults to 1h.
+	RotateEvery time.Duration
+
+	// Framed selects a length-prefixed framing of the same JSON payload
+	// instead of newline-delimited JSON, for consumers reading over a
+	// transport where a bare newline isn't a safe record separator.
+	//
+	// This deliberately stays JSON rather than protobuf: a protobuf path
+	// would need a generated Snapshot message and this tree has neither a
+	// .proto source nor a protoc-gen-go build step to produce one. Framing
+	// the existing JSON was the part of that ask this tree can actually
+	// deliver; see rotatingWriter.Write.
+	Framed bool
+
+	// Listen, if non-empty, serves Prometheus metrics on /metrics at this
+	// address (e.g. ":9090").
+	Listen string
+}
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/michaelasp/test_tcp/inetdiag"
+	"github.com/michaelasp/test_tcp/parser"
+)
+
+// tcpCloseWait is the raw tcpi_state value for CLOSE_WAIT, from the
+// kernel's enum in include/net/tcp_states.h.
+const tcpCloseWait = 8
+
+// Predicate reports whether a Snapshot identifies a socket that
+// KillMatching should destroy.
+type Predicate func(*parser.Snapshot) bool
+
+// CloseWaitIdleFor matches sockets stuck in CLOSE_WAIT - meaning the local
+// application never called close() - that have received no data for at
+// least idle. These are the classic zombie sockets a leaking application
+// piles up under load. LastDataRecv is only populated by the netlink
+// Source; snapshots from the procfs fallback or the Darwin Source never
+// match, since those paths don't report it.
+func CloseWaitIdleFor(idle time.Duration) Predicate {
+	return func(snp *parser.Snapshot) bool {
+		if snp.TCPInfo == nil || snp.TCPInfo.State != tcpCloseWait {
+			return false
+		}
+		// LastDataRecv is milliseconds elapsed, not a time.Duration.
+		return time.Duration(snp.TCPInfo.LastDataRecv)*time.Millisecond >= idle
+	}
+}
+
+// RetransmitsAbove matches sockets that have retransmitted at least n
+// segments over their lifetime, a sign of a dead or badly congested path.
+func RetransmitsAbove(n uint32) Predicate {
+	return func(snp *parser.Snapshot) bool {
+		return snp.TCPInfo != nil && snp.TCPInfo.TotalRetrans >= n
+	}
+}
+
+// Any combines predicates so a Snapshot matches if any one of them does.
+func Any(preds ...Predicate) Predicate {
+	return func(snp *parser.Snapshot) bool {
+		for _, p := range preds {
+			if p(snp) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// KillMatching issues inetdiag.Destroy for every snapshot in snps that
+// matches pred, and returns how many sockets were destroyed. It keeps
+// going after a failed Destroy so one stuck socket doesn't block
+// remediation of the rest; the first error encountered is returned
+// alongside the count.
+func KillMatching(snps []*parser.Snapshot, pred Predicate) (killed int, err error) {
+	for _, snp := range snps {
+		if snp.InetDiagMsg == nil || !pred(snp) {
+			continue
+		}
+		id := snp.InetDiagMsg.ID
+		if dErr := inetdiag.Destroy(id, snp.InetDiagMsg.IDiagFamily, syscall.IPPROTO_TCP); dErr != nil {
+			if err == nil {
+				err = dErr
+			}
+			continue
+		}
+		killed++
+	}
+	return killed, err
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/michaelasp/test_tcp/parser"
+	"github.com/michaelasp/test_tcp/tcp"
+)
+
+func snap(state uint8, lastDataRecvMs uint32, totalRetrans uint32) *parser.Snapshot {
+	return &parser.Snapshot{
+		TCPInfo: &tcp.LinuxTCPInfo{
+			State:        state,
+			LastDataRecv: lastDataRecvMs,
+			TotalRetrans: totalRetrans,
+		},
+	}
+}
+
+func TestCloseWaitIdleForMatchesIdleCloseWaitSockets(t *testing.T) {
+	pred := CloseWaitIdleFor(10 * time.Second)
+	if !pred(snap(tcpCloseWait, 15000, 0)) {
+		t.Error("want match: CLOSE_WAIT idle for 15s against a 10s threshold")
+	}
+	if pred(snap(tcpCloseWait, 5000, 0)) {
+		t.Error("want no match: CLOSE_WAIT idle for only 5s against a 10s threshold")
+	}
+	if pred(snap(1, 15000, 0)) {
+		t.Error("want no match: not in CLOSE_WAIT")
+	}
+}
+
+func TestRetransmitsAboveMatchesTotalRetrans(t *testing.T) {
+	pred := RetransmitsAbove(5)
+	if !pred(snap(0, 0, 5)) {
+		t.Error("want match: TotalRetrans equal to the threshold")
+	}
+	if pred(snap(0, 0, 4)) {
+		t.Error("want no match: TotalRetrans below the threshold")
+	}
+}
+
+func TestAnyMatchesIfAnyPredicateMatches(t *testing.T) {
+	alwaysFalse := func(*parser.Snapshot) bool { return false }
+	alwaysTrue := func(*parser.Snapshot) bool { return true }
+
+	if Any(alwaysFalse, alwaysFalse)(snap(0, 0, 0)) {
+		t.Error("want no match: every predicate false")
+	}
+	if !Any(alwaysFalse, alwaysTrue)(snap(0, 0, 0)) {
+		t.Error("want match: one predicate true")
+	}
+	if Any()(snap(0, 0, 0)) {
+		t.Error("want no match: no predicates at all")
+	}
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/michaelasp/test_tcp/parser"
+)
+
+// metrics holds the Prometheus collectors served on --listen.
+type metrics struct {
+	registry            *prometheus.Registry
+	snapshotsEmitted    prometheus.Counter
+	netlinkErrors       prometheus.Counter
+	pollLatency         prometheus.Histogram
+	connectionsObserved prometheus.Gauge
+	bytesAcked          prometheus.Gauge
+	connsByCongAlgo     *prometheus.GaugeVec
+}
+
+// newMetrics registers a fresh set of collectors against a dedicated
+// prometheus.Registry, rather than promauto's package-global
+// DefaultRegisterer, so that creating more than one metrics per process -
+// in tests, or any future caller that invokes Run more than once - doesn't
+// panic with a duplicate registration.
+func newMetrics() *metrics {
+	reg := prometheus.NewRegistry()
+	factory := promauto.With(reg)
+	return &metrics{
+		registry: reg,
+		snapshotsEmitted: factory.NewCounter(prometheus.CounterOpts{
+			Name: "tcpinfo_snapshots_emitted_total",
+			Help: "Number of changed Snapshots written to output.",
+		}),
+		netlinkErrors: factory.NewCounter(prometheus.CounterOpts{
+			Name: "tcpinfo_netlink_errors_total",
+			Help: "Number of polls that failed to collect snapshots.",
+		}),
+		pollLatency: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tcpinfo_poll_latency_seconds",
+			Help:    "Latency of a single Source.Snapshots call.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		connectionsObserved: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "tcpinfo_connections_observed",
+			Help: "Number of connections returned by the most recent poll.",
+		}),
+		bytesAcked: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "tcpinfo_bytes_acked_total",
+			Help: "Aggregate TCPInfo.BytesAcked across every connection observed in the most recent poll.",
+		}),
+		connsByCongAlgo: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "tcpinfo_connections_by_cong_algo",
+			Help: "Number of connections using each congestion control algorithm, in the most recent poll.",
+		}, []string{"cong_algo"}),
+	}
+}
+
+// updatePerPoll recomputes the per-poll gauges from this poll's full batch
+// of snapshots, replacing whatever they held after the previous poll.
+func (m *metrics) updatePerPoll(snps []*parser.Snapshot) {
+	m.connectionsObserved.Set(float64(len(snps)))
+	m.connsByCongAlgo.Reset()
+	counts := map[string]int{}
+	var bytesAcked int64
+	for _, s := range snps {
+		if s.TCPInfo != nil {
+			bytesAcked += s.TCPInfo.BytesAcked
+		}
+		if s.CongestionAlgorithm != "" {
+			counts[s.CongestionAlgorithm]++
+		}
+	}
+	m.bytesAcked.Set(float64(bytesAcked))
+	for algo, n := range counts {
+		m.connsByCongAlgo.WithLabelValues(algo).Set(float64(n))
+	}
+}
+
+// startMetricsServer serves m's registry on /metrics on addr until its
+// returned server is closed.
+func startMetricsServer(addr string, m *metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go srv.ListenAndServe()
+	return srv
+}
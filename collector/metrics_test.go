@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/michaelasp/test_tcp/parser"
+	"github.com/michaelasp/test_tcp/tcp"
+)
+
+func TestUpdatePerPollSumsBytesAcked(t *testing.T) {
+	m := newMetrics()
+	snps := []*parser.Snapshot{
+		{TCPInfo: &tcp.LinuxTCPInfo{BytesAcked: 100}},
+		{TCPInfo: &tcp.LinuxTCPInfo{BytesAcked: 250}},
+		{TCPInfo: nil},
+	}
+
+	m.updatePerPoll(snps)
+
+	if got, want := testutil.ToFloat64(m.bytesAcked), float64(350); got != want {
+		t.Errorf("bytesAcked = %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePerPollSetsConnectionsObserved(t *testing.T) {
+	m := newMetrics()
+	snps := []*parser.Snapshot{
+		{TCPInfo: &tcp.LinuxTCPInfo{}},
+		{TCPInfo: &tcp.LinuxTCPInfo{}},
+		{TCPInfo: nil},
+	}
+
+	m.updatePerPoll(snps)
+
+	if got, want := testutil.ToFloat64(m.connectionsObserved), float64(3); got != want {
+		t.Errorf("connectionsObserved = %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePerPollCountsConnectionsByCongAlgo(t *testing.T) {
+	m := newMetrics()
+	snps := []*parser.Snapshot{
+		{CongestionAlgorithm: "cubic"},
+		{CongestionAlgorithm: "cubic"},
+		{CongestionAlgorithm: "bbr"},
+		{CongestionAlgorithm: ""},
+	}
+
+	m.updatePerPoll(snps)
+
+	if got, want := testutil.ToFloat64(m.connsByCongAlgo.WithLabelValues("cubic")), float64(2); got != want {
+		t.Errorf("cubic count = %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(m.connsByCongAlgo.WithLabelValues("bbr")), float64(1); got != want {
+		t.Errorf("bbr count = %v, want %v", got, want)
+	}
+}
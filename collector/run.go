@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/michaelasp/test_tcp/cache"
+)
+
+// Run polls src on cfg.Interval, routes each batch of snapshots through a
+// change-detection cache, and writes the resulting change-only stream to
+// time-rotated files under cfg.OutDir. It blocks until ctx is canceled, at
+// which point it flushes the current file, emits a final "closed" record
+// for every connection still in the cache, and returns.
+func Run(ctx context.Context, src Source, cfg Config) error {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.RotateEvery <= 0 {
+		cfg.RotateEvery = time.Hour
+	}
+
+	var m *metrics
+	if cfg.Listen != "" {
+		m = newMetrics()
+		srv := startMetricsServer(cfg.Listen, m)
+		defer srv.Close()
+	}
+
+	w, err := newRotatingWriter(cfg.OutDir, cfg.RotateEvery, cfg.Framed)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	c := cache.NewCache()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return flushClosed(w, c, time.Now())
+		case now := <-ticker.C:
+			if err := poll(src, c, w, m, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// poll runs one Source.Snapshots call, writes every changed Snapshot and
+// every newly-closed connection's final record, and updates m if present.
+func poll(src Source, c *cache.Cache, w *rotatingWriter, m *metrics, now time.Time) error {
+	start := time.Now()
+	snps, err := src.Snapshots()
+	if m != nil {
+		m.pollLatency.Observe(time.Since(start).Seconds())
+	}
+	if err != nil {
+		if m != nil {
+			m.netlinkErrors.Inc()
+		}
+		return nil
+	}
+
+	for _, snp := range snps {
+		snp.Timestamp = now
+		if _, changed := c.Update(snp); !changed {
+			continue
+		}
+		if err := w.Write(snp); err != nil {
+			return err
+		}
+		if m != nil {
+			m.snapshotsEmitted.Inc()
+		}
+	}
+	if err := flushClosed(w, c, now); err != nil {
+		return err
+	}
+	if m != nil {
+		m.updatePerPoll(snps)
+	}
+	return nil
+}
+
+// flushClosed writes a synthetic "closed" record for every connection the
+// cache hasn't seen in the latest sweep.
+func flushClosed(w *rotatingWriter, c *cache.Cache, ts time.Time) error {
+	for _, snp := range c.Expire(ts) {
+		if err := w.Write(snp); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
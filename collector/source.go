@@ -0,0 +1,16 @@
+// Package collector abstracts over the platform-specific kernel interfaces
+// used to enumerate TCP socket state, so that main can poll for snapshots
+// without caring whether it is running on Linux or Darwin/BSD.
+package collector
+
+import "github.com/michaelasp/test_tcp/parser"
+
+// Source collects a batch of TCP socket snapshots from the underlying
+// operating system. Implementations are selected at compile time via build
+// tags, since the kernel APIs involved are not portable.
+type Source interface {
+	// Snapshots returns one parser.Snapshot per TCP socket currently known
+	// to the OS. Fields that the platform cannot provide are left zero and
+	// reflected in the returned Snapshot's NotFullyParsed bits.
+	Snapshots() ([]*parser.Snapshot, error)
+}
@@ -0,0 +1,331 @@
+//go:build darwin
+// +build darwin
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/michaelasp/test_tcp/inetdiag"
+	"github.com/michaelasp/test_tcp/parser"
+	"github.com/michaelasp/test_tcp/tcp"
+)
+
+// sizeofInetDiagMsg mirrors procfs.sizeofInetDiagMsg: sizeof(struct
+// inet_diag_msg) on the wire, 4 header bytes + 48-byte inet_diag_sockid + 5
+// trailing __u32s.
+const sizeofInetDiagMsg = 4 + 48 + 5*4
+
+// NewSource returns the Darwin/BSD Source. Darwin has no NETLINK_INET_DIAG,
+// so connections are enumerated by shelling out to netstat, which walks the
+// same net.inet.tcp.pcblist the kernel exposes.
+func NewSource() Source {
+	return &darwinSource{}
+}
+
+// NewFilteredSource exists for interface parity with the Linux Source.
+// Darwin has no kernel-side equivalent of INET_DIAG bytecode filtering, so
+// bc is accepted and ignored: callers get the same unfiltered dump as
+// NewSource.
+func NewFilteredSource(bc []byte) Source {
+	return &darwinSource{}
+}
+
+type darwinSource struct{}
+
+// darwinAttrs is the set of INET_DIAG attribute numbers that have no
+// Darwin equivalent; parseNetstatLine and snapshotFromConn mark these as observed-but-
+// unparsed so downstream consumers can tell they were never populated on
+// this platform.
+var darwinAttrs = []int{
+	inetdiag.INET_DIAG_MEMINFO,
+	inetdiag.INET_DIAG_SKMEMINFO,
+	inetdiag.INET_DIAG_CONG,
+	inetdiag.INET_DIAG_VEGASINFO,
+	inetdiag.INET_DIAG_DCTCPINFO,
+	inetdiag.INET_DIAG_BBRINFO,
+}
+
+// bsdStates maps the connection-state names netstat prints to the same
+// numeric tcp_states values the netlink and procfs Sources use (and that
+// the cache and collector.Predicate helpers assume), so a CLOSE_WAIT
+// connection looks the same regardless of platform.
+var bsdStates = map[string]uint8{
+	"CLOSED":      7,
+	"LISTEN":      10,
+	"SYN_SENT":    2,
+	"SYN_RCVD":    3,
+	"ESTABLISHED": 1,
+	"CLOSE_WAIT":  8,
+	"FIN_WAIT_1":  4,
+	"CLOSING":     11,
+	"LAST_ACK":    9,
+	"FIN_WAIT_2":  5,
+	"TIME_WAIT":   6,
+}
+
+// Snapshots enumerates every TCP connection known to the host via netstat
+// and converts each line into a parser.Snapshot.
+//
+// This is a deliberate, narrower scope than the Linux Source: Darwin has
+// no per-connection equivalent of NETLINK_INET_DIAG, so the only way to
+// get RTT/cwnd/retransmit counters is getsockopt(TCP_CONNECTION_INFO) on
+// a socket fd this process itself owns - it cannot be called against an
+// arbitrary connection netstat happens to list, since that socket lives
+// in another process. So, like the procfs fallback, the returned
+// Snapshot's TCPInfo carries only State and is marked NotFullyParsed for
+// INET_DIAG_INFO. Callers that hold their own *net.TCPConns and want real
+// RTT/cwnd/retransmit data for those specific sockets should use
+// NewConnSource instead.
+func (s *darwinSource) Snapshots() ([]*parser.Snapshot, error) {
+	out, err := exec.Command("netstat", "-an", "-p", "tcp").Output()
+	if err != nil {
+		return nil, fmt.Errorf("netstat: %w", err)
+	}
+
+	var snps []*parser.Snapshot
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		snp, err := parseNetstatLine(scanner.Text())
+		if err != nil {
+			continue
+		}
+		snps = append(snps, snp)
+	}
+	return snps, scanner.Err()
+}
+
+// NewConnSource returns a Source that reports real TCPInfo - RTT, RTTVar,
+// SndCwnd, BytesAcked, BytesReceived and Retransmits included - for exactly
+// the connections in conns, by calling getsockopt(TCP_CONNECTION_INFO) on
+// each one. Unlike NewSource, this never shells out to netstat and never
+// sees any connection this process doesn't itself hold open; it exists for
+// callers that want real data about their own sockets rather than a
+// best-effort view of the whole host.
+func NewConnSource(conns []*net.TCPConn) Source {
+	return &darwinConnSource{conns: conns}
+}
+
+type darwinConnSource struct {
+	conns []*net.TCPConn
+}
+
+// Snapshots calls getsockopt(TCP_CONNECTION_INFO) on every conn and
+// converts the result into a parser.Snapshot. A conn that errors (e.g.
+// because it has since closed) is skipped rather than failing the batch.
+func (s *darwinConnSource) Snapshots() ([]*parser.Snapshot, error) {
+	var snps []*parser.Snapshot
+	for _, conn := range s.conns {
+		snp, err := snapshotFromConn(conn)
+		if err != nil {
+			continue
+		}
+		snps = append(snps, snp)
+	}
+	return snps, nil
+}
+
+// snapshotFromConn reads conn's local/remote addresses and real kernel
+// TCPInfo via TCP_CONNECTION_INFO and assembles a parser.Snapshot from
+// them.
+func snapshotFromConn(conn *net.TCPConn) (*parser.Snapshot, error) {
+	info, err := connectionInfo(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	localIP, localPort, err := splitHostPort(conn.LocalAddr())
+	if err != nil {
+		return nil, err
+	}
+	remIP, remPort, err := splitHostPort(conn.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+	family := uint8(syscall.AF_INET)
+	if localIP.To4() == nil {
+		family = syscall.AF_INET6
+	}
+
+	raw := make(inetdiag.RawInetDiagMsg, sizeofInetDiagMsg)
+	raw[0] = family
+	raw[1] = info.State
+	binary.BigEndian.PutUint16(raw[4:6], localPort)
+	binary.BigEndian.PutUint16(raw[6:8], remPort)
+	copy(raw[8:24], padTo16(localIP))
+	copy(raw[24:40], padTo16(remIP))
+
+	idm, err := raw.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	snp := &parser.Snapshot{InetDiagMsg: idm}
+	snp.TCPInfo = info
+	snp.Observed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+	// TCP_CONNECTION_INFO covers only a few of tcp_info's fields; the rest
+	// are left zero.
+	snp.NotFullyParsed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+	for _, a := range darwinAttrs {
+		bit := uint32(1) << uint8(a-1)
+		snp.Observed |= bit
+		snp.NotFullyParsed |= bit
+	}
+	return snp, nil
+}
+
+// connectionInfo calls getsockopt(TCP_CONNECTION_INFO) on conn's underlying
+// fd and maps the kernel's struct tcp_connection_info onto a
+// tcp.LinuxTCPInfo, translating the Darwin TCPS_* state into the Linux
+// numbering bsdStates uses, and srtt/rttvar from the kernel's
+// 1/8-tick-of-a-millisecond units to the microseconds tcpi_rtt/tcpi_rttvar
+// use.
+func connectionInfo(conn *net.TCPConn) (*tcp.LinuxTCPInfo, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPConnectionInfo
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPConnectionInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_CONNECTION_INFO)
+	}); err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	// Darwin's TCPS_* values from netinet/tcp_fsm.h, in numeric order.
+	darwinStates := [...]string{"CLOSED", "LISTEN", "SYN_SENT", "SYN_RCVD", "ESTABLISHED",
+		"CLOSE_WAIT", "FIN_WAIT_1", "CLOSING", "LAST_ACK", "FIN_WAIT_2", "TIME_WAIT"}
+	var state uint8
+	if int(info.State) < len(darwinStates) {
+		state = bsdStates[darwinStates[info.State]]
+	}
+
+	return &tcp.LinuxTCPInfo{
+		State:         state,
+		Retransmits:   uint8(info.Txretransmitpackets),
+		TotalRetrans:  uint32(info.Txretransmitpackets),
+		RTT:           uint32(info.Srtt) * 1000 / 8,
+		RTTVar:        uint32(info.Rttvar) * 1000 / 8,
+		SndCwnd:       uint32(info.Snd_cwnd),
+		BytesAcked:    int64(info.Txbytes),
+		BytesReceived: int64(info.Rxbytes),
+	}, nil
+}
+
+// splitHostPort converts a net.Addr known to be a *net.TCPAddr into the
+// (IP, port) pair the rest of this file's raw inet_diag_msg building
+// expects.
+func splitHostPort(addr net.Addr) (net.IP, uint16, error) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, 0, fmt.Errorf("darwin: not a TCP address: %v", addr)
+	}
+	return tcpAddr.IP, uint16(tcpAddr.Port), nil
+}
+
+// parseNetstatLine parses a single data line of `netstat -an -p tcp`, e.g.:
+//
+//	tcp4       0      0  127.0.0.1.54321        127.0.0.1.8080         ESTABLISHED
+func parseNetstatLine(line string) (*parser.Snapshot, error) {
+	f := strings.Fields(line)
+	if len(f) < 6 || !strings.HasPrefix(f[0], "tcp") {
+		return nil, fmt.Errorf("darwin: not a tcp data line: %q", line)
+	}
+	family := uint8(syscall.AF_INET)
+	if f[0] == "tcp6" {
+		family = syscall.AF_INET6
+	}
+	localIP, localPort, err := parseNetstatAddr(f[3])
+	if err != nil {
+		return nil, err
+	}
+	remIP, remPort, err := parseNetstatAddr(f[4])
+	if err != nil {
+		return nil, err
+	}
+	state, ok := bsdStates[f[5]]
+	if !ok {
+		return nil, fmt.Errorf("darwin: unknown state %q", f[5])
+	}
+
+	raw := make(inetdiag.RawInetDiagMsg, sizeofInetDiagMsg)
+	raw[0] = family
+	raw[1] = state
+	binary.BigEndian.PutUint16(raw[4:6], localPort)
+	binary.BigEndian.PutUint16(raw[6:8], remPort)
+	copy(raw[8:24], padTo16(localIP))
+	copy(raw[24:40], padTo16(remIP))
+
+	idm, err := raw.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	snp := &parser.Snapshot{InetDiagMsg: idm}
+	snp.TCPInfo = &tcp.LinuxTCPInfo{State: state}
+	snp.Observed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+	// netstat only tells us the 5-tuple and state; everything else
+	// tcp_info would normally report is unavailable here.
+	snp.NotFullyParsed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+	for _, a := range darwinAttrs {
+		bit := uint32(1) << uint8(a-1)
+		snp.Observed |= bit
+		snp.NotFullyParsed |= bit
+	}
+	return snp, nil
+}
+
+// parseNetstatAddr splits a netstat "ADDR.PORT" field, e.g.
+// "127.0.0.1.54321" or "fe80::1.49152", on the last '.' - netstat always
+// renders the port as a final dotted segment for both address families.
+func parseNetstatAddr(field string) (net.IP, uint16, error) {
+	i := strings.LastIndex(field, ".")
+	if i < 0 {
+		return nil, 0, fmt.Errorf("darwin: malformed address %q", field)
+	}
+	host, portStr := field[:i], field[i+1:]
+	if portStr == "*" {
+		portStr = "0"
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	if host == "*" {
+		return net.IPv4zero, uint16(port), nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("darwin: malformed address %q", field)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4, uint16(port), nil
+	}
+	return ip.To16(), uint16(port), nil
+}
+
+// padTo16 right-pads a 4-byte IPv4 address to the 16-byte idiag_src/
+// idiag_dst field width; IPv6 addresses are already 16 bytes.
+func padTo16(ip net.IP) []byte {
+	if len(ip) == 16 {
+		return ip
+	}
+	out := make([]byte, 16)
+	copy(out, ip)
+	return out
+}
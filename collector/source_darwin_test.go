@@ -0,0 +1,94 @@
+//go:build darwin
+// +build darwin
+
+package collector
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNetstatAddr(t *testing.T) {
+	cases := []struct {
+		field   string
+		wantIP  string
+		wantPrt uint16
+	}{
+		{"127.0.0.1.54321", "127.0.0.1", 54321},
+		{"*.8080", "0.0.0.0", 8080},
+		{"fe80::1.443", "fe80::1", 443},
+	}
+	for _, c := range cases {
+		ip, port, err := parseNetstatAddr(c.field)
+		if err != nil {
+			t.Fatalf("parseNetstatAddr(%q): %v", c.field, err)
+		}
+		if ip.String() != c.wantIP || port != c.wantPrt {
+			t.Errorf("parseNetstatAddr(%q) = %s, %d, want %s, %d", c.field, ip, port, c.wantIP, c.wantPrt)
+		}
+	}
+}
+
+func TestParseNetstatLine(t *testing.T) {
+	snp, err := parseNetstatLine("tcp4       0      0  127.0.0.1.54321        127.0.0.1.8080         CLOSE_WAIT")
+	if err != nil {
+		t.Fatalf("parseNetstatLine: %v", err)
+	}
+	if snp.TCPInfo.State != bsdStates["CLOSE_WAIT"] {
+		t.Errorf("got State %d, want %d", snp.TCPInfo.State, bsdStates["CLOSE_WAIT"])
+	}
+	if snp.InetDiagMsg.ID.SPort() != 54321 || snp.InetDiagMsg.ID.DPort() != 8080 {
+		t.Errorf("got ports %d/%d, want 54321/8080", snp.InetDiagMsg.ID.SPort(), snp.InetDiagMsg.ID.DPort())
+	}
+}
+
+func TestParseNetstatLineRejectsNonTCPLines(t *testing.T) {
+	for _, line := range []string{
+		"Active Internet connections (including servers)",
+		"Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)",
+		"udp4       0      0  *.123                  *.*",
+	} {
+		if _, err := parseNetstatLine(line); err == nil {
+			t.Errorf("parseNetstatLine(%q): want error, got nil", line)
+		}
+	}
+}
+
+// TestConnSourceReportsRealTCPInfo dials a real loopback connection and
+// checks NewConnSource reports actual kernel TCPInfo for it - unlike
+// NewSource, which can only ever report State for a connection it didn't
+// open itself.
+func TestConnSourceReportsRealTCPInfo(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	snps, err := NewConnSource([]*net.TCPConn{client.(*net.TCPConn)}).Snapshots()
+	if err != nil {
+		t.Fatalf("Snapshots: %v", err)
+	}
+	if len(snps) != 1 {
+		t.Fatalf("got %d snapshots, want 1", len(snps))
+	}
+	if snps[0].TCPInfo.State != bsdStates["ESTABLISHED"] {
+		t.Errorf("got State %d, want ESTABLISHED (%d)", snps[0].TCPInfo.State, bsdStates["ESTABLISHED"])
+	}
+}
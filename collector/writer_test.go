@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/michaelasp/test_tcp/parser"
+)
+
+// TestRotatingWriterFramedFrameIsLengthPrefixedJSON guards against the
+// --framed path silently drifting from "length-prefixed JSON" into
+// something a JSON-expecting consumer can't decode - this writer has never
+// emitted protobuf, despite the flag once being named --proto.
+func TestRotatingWriterFramedFrameIsLengthPrefixedJSON(t *testing.T) {
+	dir := t.TempDir()
+	rw, err := newRotatingWriter(dir, time.Hour, true)
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	if err := rw.Write(&parser.Snapshot{CongestionAlgorithm: "cubic"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(matches) != 1 {
+		t.Fatalf("got %d output files, want 1", len(matches))
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(data) < 4 {
+		t.Fatalf("framed output too short: %d bytes", len(data))
+	}
+
+	wantLen := binary.BigEndian.Uint32(data[:4])
+	var snp parser.Snapshot
+	if err := json.Unmarshal(data[4:4+wantLen], &snp); err != nil {
+		t.Fatalf("framed payload isn't the advertised length of valid JSON: %v", err)
+	}
+	if snp.CongestionAlgorithm != "cubic" {
+		t.Errorf("got CongestionAlgorithm %q, want %q", snp.CongestionAlgorithm, "cubic")
+	}
+}
+
+func TestRotatingWriterUnframedIsNewlineDelimited(t *testing.T) {
+	dir := t.TempDir()
+	rw, err := newRotatingWriter(dir, time.Hour, false)
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	if err := rw.Write(&parser.Snapshot{}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("got %d .jsonl output files, want 1", len(matches))
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		t.Errorf("unframed output does not end with a newline: %q", data)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package inetdiag
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// sockDestroy is the netlink message type (SOCK_DESTROY) that asks the
+// kernel to tear down a matching socket. Supported since Linux 4.9.
+const sockDestroy = 21
+
+// noCookie is INET_DIAG_NOCOOKIE: writing it into idiag_cookie tells the
+// kernel to match purely on family/protocol/4-tuple and skip the cookie
+// check entirely. IDiagCookie is a byte array (not a pair of uint32s), so
+// this is copied in rather than assigned as a composite literal.
+var noCookie = [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Destroy sends a SOCK_DESTROY request for the socket identified by id,
+// family and protocol, and waits for the kernel's ack. This turns the tool
+// from read-only into a remediation aid: the matched socket is forcibly
+// closed, with ECONNABORTED delivered to the owning process.
+//
+// id's cookie is ignored and replaced with INET_DIAG_NOCOOKIE before the
+// request is sent. Destroy's callers can only promise the 4-tuple is
+// right - a Snapshot from the procfs fallback, for instance, carries a
+// cookie synthesized from the inode, not the kernel's real socket cookie,
+// and an exact-match request against that would just get -ENOENT.
+func Destroy(id LinuxSockID, family uint8, protocol uint8) error {
+	copy(id.IDiagCookie[:], noCookie[:])
+
+	req := nl.NewNetlinkRequest(sockDestroy, syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+
+	req.AddData(rawNetlinkData(serializeReqV2(reqV2{
+		Family:   family,
+		Protocol: protocol,
+		ID:       id,
+	})))
+
+	s, err := nl.Subscribe(syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Send(req); err != nil {
+		return err
+	}
+
+	msgs, _, err := s.Receive()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_ERROR {
+			continue
+		}
+		errno := int32(nl.NativeEndian().Uint32(m.Data[0:4]))
+		if errno != 0 {
+			return syscall.Errno(-errno)
+		}
+		return nil
+	}
+	return fmt.Errorf("inetdiag: no ack received for SOCK_DESTROY")
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package inetdiag
+
+import "fmt"
+
+// Destroy is only implemented on Linux, where SOCK_DESTROY is delivered
+// over NETLINK_INET_DIAG. On other platforms there is no equivalent
+// kernel API, so every Destroy that KillMatching issues fails here rather
+// than silently doing nothing.
+func Destroy(id LinuxSockID, family uint8, protocol uint8) error {
+	return fmt.Errorf("inetdiag: Destroy is not supported on this platform")
+}
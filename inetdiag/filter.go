@@ -0,0 +1,204 @@
+package inetdiag
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// sizeofReqV2 is the wire size of reqV2, used to lay out the raw request
+// body the same way parser.go treats RawInetDiagMsg.
+const sizeofReqV2 = int(unsafe.Sizeof(reqV2{}))
+
+// sockDiagByFamily is the netlink message type used for all INET_DIAG
+// requests and dumps.
+const sockDiagByFamily = 20
+
+// inetDiagReqBytecode is the nlattr type carrying a compiled bytecode
+// program in an inet_diag_req_v2 dump request.
+const inetDiagReqBytecode = 1
+
+// Bytecode op codes. See struct inet_diag_bc_op in
+// include/uapi/linux/inet_diag.h.
+const (
+	INET_DIAG_BC_NOP = iota
+	INET_DIAG_BC_JMP
+	INET_DIAG_BC_S_GE
+	INET_DIAG_BC_S_LE
+	INET_DIAG_BC_D_GE
+	INET_DIAG_BC_D_LE
+	INET_DIAG_BC_AUTO
+	INET_DIAG_BC_S_COND
+	INET_DIAG_BC_D_COND
+	INET_DIAG_BC_DEV_COND
+	INET_DIAG_BC_MARK_COND
+)
+
+// bcOp mirrors struct inet_diag_bc_op: the 4-byte header in front of every
+// bytecode instruction. Yes and No are byte offsets, relative to the start
+// of this op, to the next op to run when the predicate matches or fails
+// respectively.
+type bcOp struct {
+	Code uint8
+	Yes  uint8
+	No   uint16
+}
+
+// reqV2 mirrors struct inet_diag_req_v2.
+type reqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       LinuxSockID
+}
+
+// serializeReqV2 lays out body the way the kernel expects an
+// inet_diag_req_v2 on the wire. Only the Linux-only request builders in
+// filter_linux.go and destroy_linux.go call it; the layout itself has no
+// syscall dependency, so it is defined here without a build tag.
+func serializeReqV2(body reqV2) []byte {
+	buf := make([]byte, sizeofReqV2)
+	copy(buf, (*(*[sizeofReqV2]byte)(unsafe.Pointer(&body)))[:])
+	return buf
+}
+
+// Filter incrementally builds a kernel-side INET_DIAG bytecode program. Ops
+// are appended in the order their predicates should be checked; Build lays
+// them out back-to-back and patches each op's Yes/No jump offsets so a
+// failing predicate jumps straight to the terminal reject.
+type Filter struct {
+	ops [][]byte
+}
+
+// NewFilter returns an empty Filter.
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+// DstPortRange restricts matches to connections whose destination port
+// falls within [lo, hi].
+func (f *Filter) DstPortRange(lo, hi uint16) *Filter {
+	f.ops = append(f.ops, encodeOp(INET_DIAG_BC_D_GE, portOperand(lo)))
+	f.ops = append(f.ops, encodeOp(INET_DIAG_BC_D_LE, portOperand(hi)))
+	return f
+}
+
+// SrcPortRange restricts matches to connections whose source port falls
+// within [lo, hi].
+func (f *Filter) SrcPortRange(lo, hi uint16) *Filter {
+	f.ops = append(f.ops, encodeOp(INET_DIAG_BC_S_GE, portOperand(lo)))
+	f.ops = append(f.ops, encodeOp(INET_DIAG_BC_S_LE, portOperand(hi)))
+	return f
+}
+
+// SrcNet restricts matches to connections whose source address falls
+// within cidr.
+func (f *Filter) SrcNet(cidr string) *Filter {
+	if operand, ok := hostCondOperand(cidr); ok {
+		f.ops = append(f.ops, encodeOp(INET_DIAG_BC_S_COND, operand))
+	}
+	return f
+}
+
+// DstNet restricts matches to connections whose destination address falls
+// within cidr.
+func (f *Filter) DstNet(cidr string) *Filter {
+	if operand, ok := hostCondOperand(cidr); ok {
+		f.ops = append(f.ops, encodeOp(INET_DIAG_BC_D_COND, operand))
+	}
+	return f
+}
+
+// Mark restricts matches to connections whose SO_MARK, masked by mask,
+// equals mark.
+func (f *Filter) Mark(mark, mask uint32) *Filter {
+	operand := make([]byte, 8)
+	binary.LittleEndian.PutUint32(operand[0:4], mark)
+	binary.LittleEndian.PutUint32(operand[4:8], mask)
+	f.ops = append(f.ops, encodeOp(INET_DIAG_BC_MARK_COND, operand))
+	return f
+}
+
+// Build lays out the accumulated ops and patches their jump offsets: Yes
+// always falls through to the next op, No jumps past every remaining op to
+// the implicit terminal reject.
+func (f *Filter) Build() []byte {
+	if len(f.ops) == 0 {
+		return nil
+	}
+	offsets := make([]int, len(f.ops)+1)
+	total := 0
+	for i, op := range f.ops {
+		offsets[i] = total
+		total += len(op)
+	}
+	offsets[len(f.ops)] = total
+
+	out := make([]byte, 0, total)
+	for i, op := range f.ops {
+		op[1] = uint8(len(op)) // Yes: fall through to the next op
+
+		// No: inet_diag_bc_audit bounds every op's No to
+		// remaining-bytes-from-here + 4 (the 4 being one bcOp header, not a
+		// whole op-with-operand) and special-cases exactly that value as a
+		// deliberate jump past the end of the program, bypassing the usual
+		// "must land on a valid op" check. That's what lets a failing
+		// predicate reject the connection: anything less would have to
+		// land on a real op or on the program's exact end, which a
+		// successful Yes walk also reaches.
+		remaining := total - offsets[i]
+		reject := uint16(remaining + 4)
+		binary.LittleEndian.PutUint16(op[2:4], reject)
+
+		out = append(out, op...)
+	}
+	return out
+}
+
+func encodeOp(code uint8, operand []byte) []byte {
+	op := make([]byte, 4+len(operand))
+	op[0] = code
+	copy(op[4:], operand)
+	return op
+}
+
+// portOperand encodes a port for INET_DIAG_BC_{S,D}_{GE,LE}. The kernel's
+// inet_diag_bc_run doesn't read the comparison value from this op's own
+// header - it reads op[1].no, the "no" field of the following bcOp-sized
+// slot, in host (little-endian) order. So the 4-byte operand here is really
+// a dummy bcOp whose Code/Yes bytes (0-1) go unused and whose No bytes
+// (2-3) - absolute offset 6-7 of the encoded op - carry the port.
+func portOperand(port uint16) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b[2:4], port)
+	return b
+}
+
+// hostCondOperand encodes cidr as a struct inet_diag_hostcond (see
+// include/uapi/linux/inet_diag.h): family(1) + prefix_len(1), then 2 bytes
+// of compiler-inserted padding before the 4-byte `int port` field, and the
+// address starting at byte offset 8 - not offset 4, since `port` is a
+// native int rather than a 2-byte field.
+func hostCondOperand(cidr string) ([]byte, bool) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, false
+	}
+	family := uint8(syscall.AF_INET)
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		family = syscall.AF_INET6
+		ip = ipNet.IP.To16()
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+
+	b := make([]byte, 8+len(ip))
+	b[0] = family
+	b[1] = uint8(prefixLen)
+	binary.LittleEndian.PutUint32(b[4:8], 0xffffffff) // port: match any
+	copy(b[8:], ip)
+	return b, true
+}
@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package inetdiag
+
+import (
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// rawNetlinkData lets us hand a pre-serialized byte slice to
+// nl.NetlinkRequest.AddData.
+type rawNetlinkData []byte
+
+func (d rawNetlinkData) Len() int          { return len(d) }
+func (d rawNetlinkData) Serialize() []byte { return d }
+
+// MakeReqWithFilter builds a NETLINK_INET_DIAG dump request restricted to
+// the socket states in the states bitmask (same encoding as idiag_states,
+// e.g. 1<<TCP_ESTABLISHED) and carrying a compiled bytecode program bc, so
+// the kernel discards uninteresting sockets - such as ephemeral TIME_WAIT
+// connections - before they cross the netlink boundary. Pass a nil bc to
+// filter purely on states.
+func MakeReqWithFilter(family uint8, states uint32, bc []byte) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(sockDiagByFamily, syscall.NLM_F_DUMP|syscall.NLM_F_REQUEST)
+
+	req.AddData(rawNetlinkData(serializeReqV2(reqV2{
+		Family:   family,
+		Protocol: syscall.IPPROTO_TCP,
+		States:   states,
+	})))
+
+	if len(bc) > 0 {
+		req.AddData(nl.NewRtAttr(inetDiagReqBytecode, bc))
+	}
+	return req
+}
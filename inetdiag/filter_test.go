@@ -0,0 +1,141 @@
+package inetdiag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// TestFilterBuildYesWalkReachesExactlyTotalLength mirrors the kernel's
+// inet_diag_bc_run: walking the program always taking Yes (every predicate
+// matched) must land exactly on the program's length, since that's the only
+// offset the kernel treats as a match.
+func TestFilterBuildYesWalkReachesExactlyTotalLength(t *testing.T) {
+	bc := NewFilter().SrcPortRange(1, 2).Mark(1, 1).Build()
+	total := len(bc)
+
+	pos := 0
+	for pos < total {
+		pos += int(bc[pos+1]) // Yes is a 1-byte offset relative to pos
+	}
+	if pos != total {
+		t.Fatalf("all-yes walk landed at %d, want exactly %d (program length)", pos, total)
+	}
+}
+
+// TestFilterBuildNoOvershootsPastTheEnd checks that a failing predicate's No
+// offset can never land on the same absolute position a full Yes walk
+// reaches - if it did, the kernel couldn't tell a rejected connection from
+// a matched one.
+func TestFilterBuildNoOvershootsPastTheEnd(t *testing.T) {
+	bc := NewFilter().SrcPortRange(1, 2).Mark(1, 1).Build()
+	total := len(bc)
+
+	no := int(binary.LittleEndian.Uint16(bc[2:4]))
+	if no <= total {
+		t.Fatalf("first op's No offset is %d, want > %d so a reject can't be mistaken for a match", no, total)
+	}
+}
+
+// auditBC mirrors inet_diag_bc_audit's bound check: every op's No must fall
+// in [4, remaining+4] (remaining+4 being the one legal overshoot, used to
+// signal a terminal reject) and every op's Yes must fall in [4, remaining],
+// landing on the start of a later op or exactly the end of the program.
+// The kernel returns EINVAL for the whole request if any op in a real
+// bytecode program violates this.
+func auditBC(bc []byte) error {
+	total := len(bc)
+	pos := 0
+	for pos < total {
+		if pos+4 > total {
+			return fmt.Errorf("op header truncated at offset %d", pos)
+		}
+		yes := int(bc[pos+1])
+		no := int(binary.LittleEndian.Uint16(bc[pos+2 : pos+4]))
+		remaining := total - pos
+		if no < 4 || no > remaining+4 {
+			return fmt.Errorf("op at %d: No=%d out of bounds for remaining=%d", pos, no, remaining)
+		}
+		if yes < 4 || yes > remaining {
+			return fmt.Errorf("op at %d: Yes=%d out of bounds for remaining=%d", pos, yes, remaining)
+		}
+		pos += yes
+	}
+	if pos != total {
+		return fmt.Errorf("yes-walk ended at %d, want exactly %d", pos, total)
+	}
+	return nil
+}
+
+// runBC mirrors inet_diag_bc_run for the op codes Filter emits: it walks
+// the program taking Yes on a matched predicate and No otherwise, and
+// reports a match iff that walk lands exactly at len(bc). Per the kernel,
+// S_GE/S_LE/D_GE/D_LE don't read their comparison value from their own
+// operand bytes - they read op[1].no, the No field of the following
+// bcOp-sized slot, in host (little-endian) order.
+func runBC(bc []byte, sport, dport uint16, mark uint32) bool {
+	pos := 0
+	for pos < len(bc) {
+		code := bc[pos]
+		yes := int(bc[pos+1])
+		no := int(binary.LittleEndian.Uint16(bc[pos+2 : pos+4]))
+
+		matched := true
+		switch code {
+		case INET_DIAG_BC_S_GE, INET_DIAG_BC_S_LE, INET_DIAG_BC_D_GE, INET_DIAG_BC_D_LE:
+			cmp := binary.LittleEndian.Uint16(bc[pos+6 : pos+8])
+			switch code {
+			case INET_DIAG_BC_S_GE:
+				matched = sport >= cmp
+			case INET_DIAG_BC_S_LE:
+				matched = sport <= cmp
+			case INET_DIAG_BC_D_GE:
+				matched = dport >= cmp
+			case INET_DIAG_BC_D_LE:
+				matched = dport <= cmp
+			}
+		case INET_DIAG_BC_MARK_COND:
+			condMark := binary.LittleEndian.Uint32(bc[pos+4 : pos+8])
+			condMask := binary.LittleEndian.Uint32(bc[pos+8 : pos+12])
+			matched = mark&condMask == condMark
+		}
+
+		if matched {
+			pos += yes
+		} else {
+			pos += no
+		}
+	}
+	return pos == len(bc)
+}
+
+// TestFilterBuildPassesKernelAudit checks the port-range and mark predicates -
+// the case the shipped code got wrong, since a bare NOP's op has no operand
+// pushing its length past 4 - against the kernel's actual audit bound.
+func TestFilterBuildPassesKernelAudit(t *testing.T) {
+	bc := NewFilter().DstPortRange(8080, 8080).SrcPortRange(1, 1024).Mark(1, 1).Build()
+	if err := auditBC(bc); err != nil {
+		t.Fatalf("program fails kernel audit: %v", err)
+	}
+}
+
+// TestFilterBuildDstPortRangeMatchesOnlyThatPort reproduces the live-kernel
+// check: a DstPortRange(p, p) filter must match a connection whose dest
+// port is p and reject every other port, which only holds if the
+// comparison value is read from op[1].no (offset 6-7, little-endian) -
+// the offset/endianness the shipped code got wrong.
+func TestFilterBuildDstPortRangeMatchesOnlyThatPort(t *testing.T) {
+	bc := NewFilter().DstPortRange(8080, 8080).Build()
+	if err := auditBC(bc); err != nil {
+		t.Fatalf("program fails kernel audit: %v", err)
+	}
+	if !runBC(bc, 0, 8080, 0) {
+		t.Error("want match: dport == 8080")
+	}
+	if runBC(bc, 0, 9090, 0) {
+		t.Error("want no match: dport != 8080")
+	}
+	if runBC(bc, 0, 0, 0) {
+		t.Error("want no match: dport == 0")
+	}
+}
@@ -1,78 +1,127 @@
 package main
 
-// This package is only meaningful in Linux.
-
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/vishvananda/netlink/nl"
-
+	"github.com/michaelasp/test_tcp/collector"
 	"github.com/michaelasp/test_tcp/inetdiag"
-	"github.com/michaelasp/test_tcp/parser"
 )
 
-func getSnapshots(req *nl.NetlinkRequest) ([]*parser.Snapshot, error) {
-	var snps []*parser.Snapshot
-	sockType := syscall.NETLINK_INET_DIAG
-	s, err := nl.Subscribe(sockType)
+var (
+	outDir      = flag.String("out-dir", "", "if set, run continuously and write a change-only Snapshot stream to time-rotated files under this directory instead of printing a single dump")
+	interval    = flag.Duration("interval", 100*time.Millisecond, "polling interval when --out-dir is set")
+	rotateEvery = flag.Duration("rotate-every", time.Hour, "how often to rotate the output file when --out-dir is set")
+	framed      = flag.Bool("framed", false, "use a length-prefixed framing of Snapshot JSON instead of newline-delimited JSON. Still JSON, not protobuf: this tree has no generated Snapshot protobuf message to encode to")
+	listen      = flag.String("listen", "", "address to serve Prometheus /metrics on (e.g. :9090), when --out-dir is set")
+
+	dstPortRange = flag.String("dst-port-range", "", "if set (as \"lo-hi\"), restrict the dump to connections whose destination port falls in this range. Filtered kernel-side on Linux; ignored on Darwin")
+
+	killMatching   = flag.Bool("kill-matching", false, "run one dump and issue SOCK_DESTROY for every socket matching --close-wait-idle and/or --min-retransmits, instead of printing the dump")
+	closeWaitIdle  = flag.Duration("close-wait-idle", 0, "with --kill-matching, destroy CLOSE_WAIT sockets that have received no data for at least this long (0 disables this check)")
+	minRetransmits = flag.Uint("min-retransmits", 0, "with --kill-matching, destroy sockets with at least this many retransmits (0 disables this check)")
+)
+
+func main() {
+	flag.Parse()
+	src, err := newSource()
 	if err != nil {
-		return nil, err
-	}
-	defer s.Close()
-	if err := s.Send(req); err != nil {
-		return nil, err
+		fmt.Println("Error:", err)
+		return
 	}
-	pid, err := s.GetPid()
-	if err != nil {
-		return nil, err
+
+	if *killMatching {
+		runKillMatching(src)
+		return
 	}
-	// Adapted this from req.Execute in nl_linux.go
-snapshotLoop:
-	for {
 
-		msgs, _, err := s.Receive()
+	if *outDir == "" {
+		res, err := src.Snapshots()
 		if err != nil {
-			return nil, err
-		}
-		// TODO avoid the copy.
-		for i := range msgs {
-			m, shouldContinue, err := inetdiag.ProcessMessage(&msgs[i], req.Seq, pid)
-			if err != nil {
-				return nil, err
-			}
-			if m != nil {
-				cur, err := parser.MakeSnapShot(m, true)
-				if cur == nil || err != nil {
-					continue
-				}
-				snps = append(snps, cur)
-
-			}
-			if !shouldContinue {
-				break snapshotLoop
-			}
+			fmt.Println("Error getting snapshots: ", err)
 		}
+		val, _ := json.MarshalIndent(res, "", "    ")
+		fmt.Println(string(val))
+		return
+	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	cfg := collector.Config{
+		Interval:    *interval,
+		OutDir:      *outDir,
+		RotateEvery: *rotateEvery,
+		Framed:      *framed,
+		Listen:      *listen,
+	}
+	if err := collector.Run(ctx, src, cfg); err != nil {
+		fmt.Println("Error running collector: ", err)
 	}
-	return snps, nil
 }
 
-func main() {
+// newSource builds the collector.Source the rest of main uses, applying
+// --dst-port-range as a kernel-side inetdiag.Filter when set.
+func newSource() (collector.Source, error) {
+	if *dstPortRange == "" {
+		return collector.NewSource(), nil
+	}
+	lo, hi, err := parsePortRange(*dstPortRange)
+	if err != nil {
+		return nil, fmt.Errorf("--dst-port-range: %w", err)
+	}
+	bc := inetdiag.NewFilter().DstPortRange(lo, hi).Build()
+	return collector.NewFilteredSource(bc), nil
+}
 
-	req6 := inetdiag.MakeReq(syscall.AF_INET6)
-	req := inetdiag.MakeReq(syscall.AF_INET)
-	_, err := getSnapshots(req6)
+// parsePortRange parses a "lo-hi" flag value into a pair of ports.
+func parsePortRange(s string) (lo, hi uint16, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("want \"lo-hi\", got %q", s)
+	}
+	loN, err := strconv.ParseUint(parts[0], 10, 16)
 	if err != nil {
-		fmt.Println("Error getting req6: ", err)
+		return 0, 0, err
 	}
-	res, err := getSnapshots(req)
+	hiN, err := strconv.ParseUint(parts[1], 10, 16)
 	if err != nil {
-		fmt.Println("Error getting req6: ", err)
+		return 0, 0, err
+	}
+	return uint16(loN), uint16(hiN), nil
+}
+
+// runKillMatching takes one snapshot dump and destroys every socket that
+// matches the configured --close-wait-idle and/or --min-retransmits
+// thresholds, turning the tool into a remediation aid for zombie
+// connections instead of a read-only reporter.
+func runKillMatching(src collector.Source) {
+	if *closeWaitIdle <= 0 && *minRetransmits == 0 {
+		fmt.Println("Error: --kill-matching requires --close-wait-idle and/or --min-retransmits")
+		return
+	}
+	var preds []collector.Predicate
+	if *closeWaitIdle > 0 {
+		preds = append(preds, collector.CloseWaitIdleFor(*closeWaitIdle))
+	}
+	if *minRetransmits > 0 {
+		preds = append(preds, collector.RetransmitsAbove(uint32(*minRetransmits)))
 	}
-	val, _ := json.MarshalIndent(res, "", "    ")
-	fmt.Println(string(val))
-	return
 
+	snps, err := src.Snapshots()
+	if err != nil {
+		fmt.Println("Error getting snapshots: ", err)
+		return
+	}
+	killed, err := collector.KillMatching(snps, collector.Any(preds...))
+	if err != nil {
+		fmt.Println("Error destroying sockets: ", err)
+	}
+	fmt.Printf("Destroyed %d matching socket(s)\n", killed)
 }
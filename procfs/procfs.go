@@ -0,0 +1,211 @@
+// Package procfs is a fallback TCP connection source based on
+// /proc/net/tcp and /proc/net/tcp6, for environments where
+// NETLINK_INET_DIAG isn't available - containers, gVisor sandboxes, or user
+// namespaces without CAP_NET_ADMIN.
+package procfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/michaelasp/test_tcp/inetdiag"
+	"github.com/michaelasp/test_tcp/parser"
+	"github.com/michaelasp/test_tcp/tcp"
+)
+
+const (
+	tcp4Path = "/proc/net/tcp"
+	tcp6Path = "/proc/net/tcp6"
+
+	// sizeofInetDiagMsg is sizeof(struct inet_diag_msg) on the wire: 4
+	// header bytes, a 48-byte inet_diag_sockid, and 5 trailing __u32s.
+	sizeofInetDiagMsg = 4 + 48 + 5*4
+)
+
+// Snapshots scans /proc/net/tcp and /proc/net/tcp6 and returns one
+// parser.Snapshot per listed socket. It is meant as a drop-in substitute
+// for the netlink path, sharing its address-family parsing and endian
+// conventions by building the same wire-format inet_diag_msg and handing
+// it to inetdiag.RawInetDiagMsg.Parse.
+func Snapshots() ([]*parser.Snapshot, error) {
+	var snps []*parser.Snapshot
+	for _, f := range []struct {
+		path   string
+		family uint8
+	}{
+		{tcp4Path, syscall.AF_INET},
+		{tcp6Path, syscall.AF_INET6},
+	} {
+		s, err := snapshotsFromFile(f.path, f.family)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		snps = append(snps, s...)
+	}
+	return snps, nil
+}
+
+// snapshotsFromFile parses every data line of a /proc/net/{tcp,tcp6} file.
+func snapshotsFromFile(path string, family uint8) ([]*parser.Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snps []*parser.Snapshot
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header line
+	for scanner.Scan() {
+		snp, err := parseLine(scanner.Text(), family)
+		if err != nil {
+			continue
+		}
+		snps = append(snps, snp)
+	}
+	return snps, scanner.Err()
+}
+
+// parseLine parses a single data line of /proc/net/tcp{,6}, e.g.:
+//
+//	1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 54321 1 0000000000000000 100 0 0 10 0
+func parseLine(line string, family uint8) (*parser.Snapshot, error) {
+	f := strings.Fields(line)
+	if len(f) < 10 {
+		return nil, fmt.Errorf("procfs: short line: %q", line)
+	}
+
+	localIP, localPort, err := parseAddr(f[1])
+	if err != nil {
+		return nil, err
+	}
+	remIP, remPort, err := parseAddr(f[2])
+	if err != nil {
+		return nil, err
+	}
+	state, err := strconv.ParseUint(f[3], 16, 8)
+	if err != nil {
+		return nil, err
+	}
+	txQueue, rxQueue, err := parseQueues(f[4])
+	if err != nil {
+		return nil, err
+	}
+	retransmits, err := strconv.ParseUint(f[6], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(f[7], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	inode, err := strconv.ParseUint(f[9], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(inetdiag.RawInetDiagMsg, sizeofInetDiagMsg)
+	raw[0] = family
+	raw[1] = uint8(state)
+	binary.BigEndian.PutUint16(raw[4:6], localPort)
+	binary.BigEndian.PutUint16(raw[6:8], remPort)
+	copy(raw[8:24], padTo16(localIP))
+	copy(raw[24:40], padTo16(remIP))
+	// idiag_cookie: procfs has no netlink cookie, so synthesize one from
+	// the (stable, per-socket) inode to give the connection a usable key.
+	binary.LittleEndian.PutUint32(raw[44:48], uint32(inode))
+	binary.LittleEndian.PutUint32(raw[56:60], uint32(rxQueue))
+	binary.LittleEndian.PutUint32(raw[60:64], uint32(txQueue))
+	binary.LittleEndian.PutUint32(raw[64:68], uint32(uid))
+	binary.LittleEndian.PutUint32(raw[68:72], uint32(inode))
+
+	idm, err := raw.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	snp := &parser.Snapshot{InetDiagMsg: idm}
+	snp.TCPInfo = &tcp.LinuxTCPInfo{
+		State: uint8(state),
+		// /proc/net/tcp's "retrnsmt" field is icsk_retransmits, the kernel's
+		// current RTO backoff count (resets on recovery) - the same thing
+		// Retransmits tracks, not the cumulative TotalRetrans.
+		Retransmits: uint8(retransmits),
+	}
+	snp.Observed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+	// /proc/net/tcp only tells us retransmits and state; everything else
+	// tcp_info would normally report, including TotalRetrans, is
+	// unavailable here.
+	snp.NotFullyParsed |= uint32(1) << uint8(inetdiag.INET_DIAG_INFO-1)
+
+	// /proc/net/tcp has no equivalent of INET_DIAG_SKMEMINFO, so SocketMem
+	// is left nil rather than set to a fabricated all-zero struct - Observed
+	// must stay unset here too, or a consumer would trust those zeros as a
+	// real reading instead of an absent one.
+
+	return snp, nil
+}
+
+// parseAddr decodes a procfs "ADDR:PORT" field, e.g. "0100007F:1F90". The
+// address is hex-encoded with each 32-bit word stored little-endian, unlike
+// the network-byte-order the kernel uses on the wire; this re-orders the
+// words so the result matches what the netlink path would hand to
+// inetdiag.RawInetDiagMsg.Parse.
+func parseAddr(field string) (net.IP, uint16, error) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("procfs: malformed address %q", field)
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i+4 <= len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, uint16(port), nil
+}
+
+// parseQueues decodes the "tx_queue:rx_queue" field into SocketMem-style
+// byte counts.
+func parseQueues(field string) (tx, rx uint64, err error) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("procfs: malformed queue field %q", field)
+	}
+	tx, err = strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	rx, err = strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return tx, rx, nil
+}
+
+// padTo16 right-pads a 4-byte IPv4 address to the 16-byte idiag_src/
+// idiag_dst field width; IPv6 addresses are already 16 bytes.
+func padTo16(ip net.IP) []byte {
+	if len(ip) == 16 {
+		return ip
+	}
+	out := make([]byte, 16)
+	copy(out, ip)
+	return out
+}
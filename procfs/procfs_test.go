@@ -0,0 +1,53 @@
+package procfs
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	ip, port, err := parseAddr("0100007F:1F90")
+	if err != nil {
+		t.Fatalf("parseAddr: %v", err)
+	}
+	if ip.String() != "127.0.0.1" || port != 8080 {
+		t.Errorf("got %s:%d, want 127.0.0.1:8080", ip, port)
+	}
+}
+
+func TestParseAddrMalformed(t *testing.T) {
+	if _, _, err := parseAddr("not-an-address"); err == nil {
+		t.Fatal("want error for malformed address, got nil")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	// A LISTEN socket on 127.0.0.1:8080, owned by uid 1000, inode 54321,
+	// with 3 retransmits in the kernel's current RTO backoff count.
+	line := "1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000003  1000        0 54321 1 0000000000000000 100 0 0 10 0"
+
+	snp, err := parseLine(line, syscall.AF_INET)
+	if err != nil {
+		t.Fatalf("parseLine: %v", err)
+	}
+	if got := snp.InetDiagMsg.ID.SPort(); got != 8080 {
+		t.Errorf("got local port %d, want 8080", got)
+	}
+	if snp.TCPInfo.State != 0x0A {
+		t.Errorf("got state %#x, want 0x0a (LISTEN)", snp.TCPInfo.State)
+	}
+	// "retrnsmt" maps to Retransmits (the current backoff count), not the
+	// cumulative TotalRetrans, which procfs can't populate at all.
+	if snp.TCPInfo.Retransmits != 3 {
+		t.Errorf("got Retransmits %d, want 3", snp.TCPInfo.Retransmits)
+	}
+	if snp.TCPInfo.TotalRetrans != 0 {
+		t.Errorf("got TotalRetrans %d, want 0 (unavailable via procfs)", snp.TCPInfo.TotalRetrans)
+	}
+}
+
+func TestParseLineRejectsShortLines(t *testing.T) {
+	if _, err := parseLine("1: 0100007F:1F90", syscall.AF_INET); err == nil {
+		t.Fatal("want error for a short line, got nil")
+	}
+}